@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	EventsPath = "/items/events"
+	// Comment lines keep idle connections open through proxies.
+	eventsKeepAlive = 15 * time.Second
+)
+
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// eventHub fans out item events to the connected SSE clients.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+	// done is closed on server shutdown to end the streams.
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+var itemEvents = &eventHub{subs: map[chan Event]struct{}{}, done: make(chan struct{})}
+
+func (h *eventHub) subscribe() chan Event {
+	ch := make(chan Event, 16)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// shutdown ends every stream. http.Server.Shutdown does not cancel request
+// contexts, so the streams would otherwise hold it until its timeout.
+func (h *eventHub) shutdown() {
+	h.doneOnce.Do(func() { close(h.done) })
+}
+
+// publish never blocks: events are dropped for clients that are not keeping up.
+func (h *eventHub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// getItemEvents streams item_created/item_updated/item_deleted events as
+// server-sent events until the client disconnects or the server shuts down.
+func getItemEvents(c echo.Context) error {
+	ch := itemEvents.subscribe()
+	defer itemEvents.unsubscribe(ch)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-itemEvents.done:
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case ev := <-ch:
+			data, err := json.Marshal(ev.Data)
+			if err != nil {
+				c.Logger().Errorf("Failed to encode event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+	}
+}
@@ -1,11 +1,30 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"image"
+	// Registers the jpg decoder used by image.Decode
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -14,66 +33,906 @@ import (
 
 const (
 	ImgDir = "images"
+	Addr   = ":9000"
 )
 
+const (
+	defaultFrontURL = "http://localhost:3000"
+	defaultLogLevel = "INFO"
+	// compress/gzip.DefaultCompression
+	defaultGzipLevel      = -1
+	defaultGzipMinLength  = 1024
+	defaultRequestTimeout = 10 * time.Second
+	shutdownTimeout       = 10 * time.Second
+	defaultImgPlaceholder = "default.jpg"
+	defaultCORSMaxAge     = 3600
+	defaultLargestImages  = 20
+)
+
+var logLevels = map[string]log.Lvl{
+	"DEBUG": log.DEBUG,
+	"INFO":  log.INFO,
+	"WARN":  log.WARN,
+	"ERROR": log.ERROR,
+}
+
+// imgPlaceholder is served by getImg when the requested image does not exist.
+var imgPlaceholder = defaultImgPlaceholder
+
+// storedImgFormat is the format images are stored in. It is served as is.
+const storedImgFormat = "jpg"
+
+// imgEncoders are the formats getImg can transcode stored images to.
+var imgEncoders = map[string]func(io.Writer, image.Image) error{
+	"png": png.Encode,
+}
+
+// maxConvertedImgBytes bounds the total size of transcoded images kept in
+// memory.
+const maxConvertedImgBytes = 64 << 20
+
+// convertedImg is a transcoded image, valid while the source is unmodified.
+type convertedImg struct {
+	modTime time.Time
+	data    []byte
+}
+
+// convertCall is an in-flight conversion shared by concurrent requests for
+// the same image.
+type convertCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+var (
+	convertedImgsMu sync.Mutex
+	convertedImgs   = map[string]convertedImg{}
+	// convertedImgKeys is the insertion order of convertedImgs, oldest first.
+	convertedImgKeys []string
+	// convertedImgsSize is the total size of convertedImgs in bytes.
+	convertedImgsSize int
+	convertingImgs    = map[string]*convertCall{}
+)
+
+// shutdownTracing flushes buffered spans. It is set by newServer and called
+// on exit.
+var shutdownTracing = func(context.Context) error { return nil }
+
+// ready is 1 from the end of startup until shutdown begins.
+var ready int32
+
+// readOnly is 1 while in maintenance mode. Mutating handlers are guarded by
+// blockInReadOnly and return 503 while it is set.
+var readOnly int32
+
 type Response struct {
 	Message string `json:"message"`
 }
 
+type ErrorResponse struct {
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type Route struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Handler string `json:"handler"`
+}
+
+type Routes struct {
+	Routes []Route `json:"routes"`
+}
+
+type ImageFile struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+type ImageFiles struct {
+	Images []ImageFile `json:"images"`
+}
+
 func root(c echo.Context) error {
 	res := Response{Message: "Hello, world!"}
 	return c.JSON(http.StatusOK, res)
 }
 
+// getRoutes lists the registered routes, excluding itself and Echo's
+// internal not-found routes.
+func getRoutes(c echo.Context) error {
+	routes := []Route{}
+	for _, r := range c.Echo().Routes() {
+		if r.Path == c.Path() || r.Method == echo.RouteNotFound {
+			continue
+		}
+		routes = append(routes, Route{Method: r.Method, Path: r.Path, Handler: r.Name})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return c.JSON(http.StatusOK, Routes{Routes: routes})
+}
+
+// livez reports that the process is alive.
+func livez(c echo.Context) error {
+	res := Response{Message: "ok"}
+	return c.JSON(http.StatusOK, res)
+}
+
+// readyz reports whether the server can serve traffic: it is not starting up
+// or shutting down, and ImgDir is readable.
+func readyz(c echo.Context) error {
+	if atomic.LoadInt32(&ready) != 1 {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "not ready: starting up or shutting down")
+	}
+	if _, err := os.Stat(ImgDir); err != nil {
+		c.Logger().Errorf("Image directory is not accessible: %v", err)
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "not ready: image directory is not accessible")
+	}
+	res := Response{Message: "ok"}
+	return c.JSON(http.StatusOK, res)
+}
+
 func addItem(c echo.Context) error {
 	// Get form data
 	name := c.FormValue("name")
 	c.Logger().Infof("Receive item: %s", name)
 
+	itemWebhook.notify(c.Echo().Logger, map[string]string{"event": "item_created", "name": name})
+	itemEvents.publish(Event{Type: "item_created", Data: map[string]string{"name": name}})
+
 	message := fmt.Sprintf("item received: %s", name)
 	res := Response{Message: message}
 
 	return c.JSON(http.StatusOK, res)
 }
 
+// getImg serves GET and HEAD for an image, optionally transcoded with
+// ?format=. A missing image is answered by imgNotFound for GET and by a bare
+// 404 for HEAD.
 func getImg(c echo.Context) error {
 	// Create image path
 	imgPath := path.Join(ImgDir, c.Param("imageFilename"))
 
 	if !strings.HasSuffix(imgPath, ".jpg") {
-		res := Response{Message: "Image path does not end with .jpg"}
-		return c.JSON(http.StatusBadRequest, res)
+		return echo.NewHTTPError(http.StatusBadRequest, "Image path does not end with .jpg")
 	}
-	if _, err := os.Stat(imgPath); err != nil {
+	format := c.QueryParam("format")
+	if _, ok := imgEncoders[format]; format != "" && format != storedImgFormat && !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unsupported image format: %q", format))
+	}
+	info, err := os.Stat(imgPath)
+	if err != nil {
 		c.Logger().Debugf("Image not found: %s", imgPath)
-		imgPath = path.Join(ImgDir, "default.jpg")
+		if c.Request().Method == http.MethodHead {
+			return echo.NewHTTPError(http.StatusNotFound, "Image not found")
+		}
+		return imgNotFound(c)
+	}
+	if format == "" || format == storedImgFormat {
+		setETag(c, info, "")
+		return serveImg(c, imgPath)
 	}
-	return c.File(imgPath)
+
+	data, err := convertImg(c.Request().Context(), imgPath, info, format)
+	if err != nil {
+		return err
+	}
+	setETag(c, info, format)
+	c.Response().Header().Set(echo.HeaderContentType, "image/"+format)
+	http.ServeContent(c.Response(), c.Request(), "", info.ModTime(), bytes.NewReader(data))
+	return nil
 }
 
-func main() {
+// serveImg serves the image with http.ServeContent, which handles Range
+// requests (206 Partial Content) and conditional requests.
+func serveImg(c echo.Context, imgPath string) error {
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	http.ServeContent(c.Response(), c.Request(), info.Name(), info.ModTime(), f)
+	return nil
+}
+
+// imgNotFound responds 404 with the JSON error when the client accepts JSON,
+// and with the placeholder image (IMG_PLACEHOLDER in ImgDir) otherwise.
+func imgNotFound(c echo.Context) error {
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON) {
+		return echo.NewHTTPError(http.StatusNotFound, "Image not found")
+	}
+
+	f, err := os.Open(path.Join(ImgDir, imgPlaceholder))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Stream(http.StatusNotFound, mime.TypeByExtension(path.Ext(imgPlaceholder)), f)
+}
+
+// convertImg transcodes the image at imgPath to format, caching the result.
+// Concurrent requests for the same image share one conversion. It returns
+// ctx.Err() once the request deadline has passed; the conversion still
+// completes and is cached for the next request.
+func convertImg(ctx context.Context, imgPath string, info os.FileInfo, format string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key := imgPath + ":" + format
+	convertedImgsMu.Lock()
+	if cached, ok := convertedImgs[key]; ok && cached.modTime.Equal(info.ModTime()) {
+		convertedImgsMu.Unlock()
+		return cached.data, nil
+	}
+	call, ok := convertingImgs[key]
+	if !ok {
+		call = &convertCall{done: make(chan struct{})}
+		convertingImgs[key] = call
+		go func() {
+			call.data, call.err = transcodeImg(imgPath, format)
+			convertedImgsMu.Lock()
+			delete(convertingImgs, key)
+			if call.err == nil {
+				cacheConvertedImg(key, convertedImg{modTime: info.ModTime(), data: call.data})
+			}
+			convertedImgsMu.Unlock()
+			close(call.done)
+		}()
+	}
+	convertedImgsMu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.data, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func transcodeImg(imgPath string, format string) ([]byte, error) {
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := imgEncoders[format](&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cacheConvertedImg stores img, evicting the oldest entries until the cache
+// fits in maxConvertedImgBytes. Images larger than that are not cached.
+// convertedImgsMu must be held.
+func cacheConvertedImg(key string, img convertedImg) {
+	if len(img.data) > maxConvertedImgBytes {
+		return
+	}
+	if old, ok := convertedImgs[key]; ok {
+		convertedImgsSize -= len(old.data)
+	} else {
+		convertedImgKeys = append(convertedImgKeys, key)
+	}
+	convertedImgs[key] = img
+	convertedImgsSize += len(img.data)
+	for convertedImgsSize > maxConvertedImgBytes {
+		oldest := convertedImgKeys[0]
+		convertedImgsSize -= len(convertedImgs[oldest].data)
+		delete(convertedImgs, oldest)
+		convertedImgKeys = convertedImgKeys[1:]
+	}
+}
+
+// setETag sets a weak ETag derived from the file size and modification time,
+// plus the format when the image was transcoded. Last-Modified is set by
+// http.ServeContent.
+func setETag(c echo.Context, info os.FileInfo, format string) {
+	etag := fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+	if format != "" {
+		etag = fmt.Sprintf(`W/"%x-%x-%s"`, info.Size(), info.ModTime().UnixNano(), format)
+	}
+	c.Response().Header().Set("ETag", etag)
+}
+
+// getImagesZip streams every file in ImgDir as a zip archive.
+func getImagesZip(c echo.Context) error {
+	entries, err := os.ReadDir(ImgDir)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="images.zip"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(c.Response())
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := addFileToZip(zw, path.Join(ImgDir, entry.Name())); err != nil {
+			return err
+		}
+		c.Response().Flush()
+	}
+	return zw.Close()
+}
+
+// getLargestImages returns the n (default 20) largest files in ImgDir.
+func getLargestImages(c echo.Context) error {
+	n := defaultLargestImages
+	if q := c.QueryParam("n"); q != "" {
+		v, err := strconv.Atoi(q)
+		if err != nil || v <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid n: %q", q))
+		}
+		n = v
+	}
+
+	entries, err := os.ReadDir(ImgDir)
+	if err != nil {
+		return err
+	}
+	images := []ImageFile{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		images = append(images, ImageFile{Filename: entry.Name(), Size: info.Size()})
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Size > images[j].Size
+	})
+	if len(images) > n {
+		images = images[:n]
+	}
+	return c.JSON(http.StatusOK, ImageFiles{Images: images})
+}
+
+func addFileToZip(zw *zip.Writer, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	// jpgs are already compressed
+	header.Method = zip.Store
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func setLogLevel(c echo.Context) error {
+	name := strings.ToUpper(c.FormValue("level"))
+	lvl, ok := logLevels[name]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown log level: %q", c.FormValue("level")))
+	}
+	c.Echo().Logger.SetLevel(lvl)
+	c.Logger().Infof("Log level changed to %s", name)
+
+	res := Response{Message: fmt.Sprintf("log level set to %s", name)}
+	return c.JSON(http.StatusOK, res)
+}
+
+func setReadOnly(c echo.Context) error {
+	enabled, err := strconv.ParseBool(c.FormValue("enabled"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid enabled: %q", c.FormValue("enabled")))
+	}
+	setReadOnlyMode(c.Echo().Logger, enabled)
+
+	res := Response{Message: fmt.Sprintf("read-only mode: %t", enabled)}
+	return c.JSON(http.StatusOK, res)
+}
+
+func setReadOnlyMode(logger echo.Logger, enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&readOnly, v)
+	logger.Infof("Read-only mode: %t", enabled)
+}
+
+// blockInReadOnly rejects requests with 503 while in read-only mode.
+func blockInReadOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if atomic.LoadInt32(&readOnly) == 1 {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "Service is under maintenance (read-only mode)")
+		}
+		return next(c)
+	}
+}
+
+// writeContentTypes are the request bodies mutating handlers understand.
+var writeContentTypes = map[string]bool{
+	echo.MIMEMultipartForm:   true,
+	echo.MIMEApplicationForm: true,
+	echo.MIMEApplicationJSON: true,
+}
+
+// requireContentType is applied to mutating routes. It rejects POST/PUT/PATCH
+// requests whose Content-Type is missing or not one of writeContentTypes
+// with 415.
+func requireContentType(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Request().Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			return next(c)
+		}
+		ct := c.Request().Header.Get(echo.HeaderContentType)
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || !writeContentTypes[mediaType] {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported Content-Type: %q", ct))
+		}
+		return next(c)
+	}
+}
+
+// apiKeyAuth protects admin routes with the API_KEY env var, sent as
+// "Authorization: Bearer <key>". When API_KEY is unset every request is rejected.
+func apiKeyAuth() echo.MiddlewareFunc {
+	apiKey := os.Getenv("API_KEY")
+	return middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		if apiKey == "" {
+			return false, nil
+		}
+		return subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1, nil
+	})
+}
+
+// logLevel returns the level configured by LOG_LEVEL (DEBUG/INFO/WARN/ERROR).
+func logLevel() (log.Lvl, error) {
+	name := strings.ToUpper(os.Getenv("LOG_LEVEL"))
+	if name == "" {
+		name = defaultLogLevel
+	}
+	lvl, ok := logLevels[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid LOG_LEVEL: %q", os.Getenv("LOG_LEVEL"))
+	}
+	return lvl, nil
+}
+
+// envInt reads an integer env var, returning def when it is unset.
+func envInt(name string, def int) (int, error) {
+	env := os.Getenv(name)
+	if env == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(env)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, env)
+	}
+	return v, nil
+}
+
+// gzipConfig reads GZIP_LEVEL (-1 to 9) and GZIP_MIN_LENGTH (bytes).
+// Responses shorter than GZIP_MIN_LENGTH are sent uncompressed.
+func gzipConfig() (middleware.GzipConfig, error) {
+	level, err := envInt("GZIP_LEVEL", defaultGzipLevel)
+	if err != nil {
+		return middleware.GzipConfig{}, err
+	}
+	if level < -1 || level > 9 {
+		return middleware.GzipConfig{}, fmt.Errorf("GZIP_LEVEL must be between -1 and 9: %d", level)
+	}
+	minLength, err := envInt("GZIP_MIN_LENGTH", defaultGzipMinLength)
+	if err != nil {
+		return middleware.GzipConfig{}, err
+	}
+	if minLength < 0 {
+		return middleware.GzipConfig{}, fmt.Errorf("GZIP_MIN_LENGTH must not be negative: %d", minLength)
+	}
+
+	return middleware.GzipConfig{
+		// jpgs and zips are already compressed, and event streams must not be
+		// buffered
+		Skipper: func(c echo.Context) bool {
+			p := c.Request().URL.Path
+			return strings.HasPrefix(p, "/image/") || strings.HasSuffix(p, ".zip") || p == EventsPath
+		},
+		Level:     level,
+		MinLength: minLength,
+	}, nil
+}
+
+// jsonSerializer pretty-prints responses when ?pretty=true is given, or by
+// default when DEBUG_PRETTY is set. ?pretty=false forces compact output.
+type jsonSerializer struct {
+	echo.DefaultJSONSerializer
+	pretty bool
+}
+
+func (s jsonSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	pretty := s.pretty
+	if q := c.QueryParam("pretty"); q != "" {
+		pretty, _ = strconv.ParseBool(q)
+	} else if _, ok := c.QueryParams()["pretty"]; ok {
+		pretty = true
+	}
+
+	indent = ""
+	if pretty {
+		indent = "  "
+	}
+	return s.DefaultJSONSerializer.Serialize(c, i, indent)
+}
+
+// timeoutConfig reads REQUEST_TIMEOUT (e.g. "10s"). Each request gets a
+// context with that deadline; image conversion gives up once it has passed,
+// and the resulting context.DeadlineExceeded is answered with a 503. Admin
+// endpoints and the event stream are excluded since they may legitimately
+// run longer.
+func timeoutConfig() (middleware.ContextTimeoutConfig, error) {
+	timeout := defaultRequestTimeout
+	if env := os.Getenv("REQUEST_TIMEOUT"); env != "" {
+		d, err := time.ParseDuration(env)
+		if err != nil || d <= 0 {
+			return middleware.ContextTimeoutConfig{}, fmt.Errorf("invalid REQUEST_TIMEOUT: %q", env)
+		}
+		timeout = d
+	}
+
+	return middleware.ContextTimeoutConfig{
+		Skipper: func(c echo.Context) bool {
+			p := c.Request().URL.Path
+			return strings.HasPrefix(p, "/admin/") || p == EventsPath
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "Request timed out").SetInternal(err)
+			}
+			return err
+		},
+		Timeout: timeout,
+	}, nil
+}
+
+// errorHandler wraps every error, including those produced by Echo itself
+// (404 for an unknown route, 405 method not allowed, ...) and recovered
+// panics, into ErrorResponse.
+func errorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	code := http.StatusInternalServerError
+	message := http.StatusText(code)
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code = he.Code
+		switch m := he.Message.(type) {
+		case string:
+			message = m
+		case error:
+			message = m.Error()
+		default:
+			message = fmt.Sprint(m)
+		}
+	}
+	if code >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(code)
+	} else {
+		err = c.JSON(code, ErrorResponse{
+			Message:   message,
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		})
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// corsOrigins returns the origins allowed by CORS.
+// CORS_ORIGINS is a comma-separated list, e.g. "https://stg.example.com,https://example.com".
+func corsOrigins() ([]string, error) {
+	env := os.Getenv("CORS_ORIGINS")
+	if env == "" {
+		env = os.Getenv("FRONT_URL")
+	}
+	if env == "" {
+		env = defaultFrontURL
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(env, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid CORS origin: %q", origin)
+		}
+		// Echo compares the Origin header as an exact string, which never
+		// carries a path (not even a trailing slash), query, fragment or user info.
+		if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid CORS origin: %q: must be scheme://host[:port] without path, query, fragment or user info", origin)
+		}
+		origins = append(origins, origin)
+	}
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("no CORS origins configured")
+	}
+	return origins, nil
+}
+
+// logConfig logs the effective configuration at startup. Secrets are redacted.
+func logConfig(logger echo.Logger, settings log.JSON) {
+	settings["message"] = "Effective configuration"
+	settings["api_key"] = "(unset)"
+	if os.Getenv("API_KEY") != "" {
+		settings["api_key"] = "(redacted)"
+	}
+	logger.Infoj(settings)
+}
+
+func lvlName(lvl log.Lvl) string {
+	for name, l := range logLevels {
+		if l == lvl {
+			return name
+		}
+	}
+	return ""
+}
+
+// newServer builds the Echo server with its middleware and routes from the
+// environment. Invalid settings are returned as errors.
+func newServer() (*echo.Echo, error) {
 	e := echo.New()
+	e.HTTPErrorHandler = errorHandler
+
+	debugPretty := false
+	if env := os.Getenv("DEBUG_PRETTY"); env != "" {
+		v, err := strconv.ParseBool(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEBUG_PRETTY: %q", env)
+		}
+		debugPretty = v
+	}
+	e.JSONSerializer = jsonSerializer{pretty: debugPretty}
 
 	// Middleware
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-	e.Logger.SetLevel(log.INFO)
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
+		// Log the stack and hand a plain 500 to errorHandler
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			c.Logger().Errorf("[PANIC RECOVER] request_id=%s %v %s",
+				c.Response().Header().Get(echo.HeaderXRequestID), err, stack)
+			return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+		},
+	}))
+	tracing, shutdown, err := tracingMiddleware()
+	if err != nil {
+		return nil, err
+	}
+	if tracing != nil {
+		e.Use(tracing)
+	}
+	shutdownTracing = shutdown
 
-	front_url := os.Getenv("FRONT_URL")
-	if front_url == "" {
-		front_url = "http://localhost:3000"
+	timeoutCfg, err := timeoutConfig()
+	if err != nil {
+		return nil, err
+	}
+	e.Use(middleware.ContextTimeoutWithConfig(timeoutCfg))
+	// Bound slow clients sending the request too. There is no WriteTimeout:
+	// it would also cut off the event stream and the zip download.
+	e.Server.ReadTimeout = timeoutCfg.Timeout
+
+	gzipCfg, err := gzipConfig()
+	if err != nil {
+		return nil, err
+	}
+	e.Use(middleware.GzipWithConfig(gzipCfg))
+
+	lvl, err := logLevel()
+	if err != nil {
+		return nil, err
+	}
+	e.Logger.SetLevel(lvl)
+
+	if env := os.Getenv("READ_ONLY"); env != "" {
+		enabled, err := strconv.ParseBool(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid READ_ONLY: %q", env)
+		}
+		setReadOnlyMode(e.Logger, enabled)
+	}
+
+	origins, err := corsOrigins()
+	if err != nil {
+		return nil, err
+	}
+	corsMaxAge, err := envInt("CORS_MAX_AGE", defaultCORSMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	if corsMaxAge < 0 {
+		return nil, fmt.Errorf("CORS_MAX_AGE must not be negative: %d", corsMaxAge)
 	}
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{front_url},
+		AllowOrigins: origins,
 		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
+		// Seconds browsers may cache the preflight result
+		MaxAge: corsMaxAge,
 	}))
 
+	if env := os.Getenv("IMG_PLACEHOLDER"); env != "" {
+		imgPlaceholder = env
+	}
+	if _, err := os.Stat(path.Join(ImgDir, imgPlaceholder)); err != nil {
+		return nil, fmt.Errorf("placeholder image not found: %v", err)
+	}
+	if t := mime.TypeByExtension(path.Ext(imgPlaceholder)); !strings.HasPrefix(t, "image/") {
+		return nil, fmt.Errorf("IMG_PLACEHOLDER is not an image: %q", imgPlaceholder)
+	}
+
+	if webhookURL := os.Getenv("WEBHOOK_URL"); webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid WEBHOOK_URL: %q", webhookURL)
+		}
+		retries, err := envInt("WEBHOOK_RETRIES", defaultWebhookRetries)
+		if err != nil {
+			return nil, err
+		}
+		if retries < 0 {
+			return nil, fmt.Errorf("WEBHOOK_RETRIES must not be negative: %d", retries)
+		}
+		itemWebhook = newWebhook(webhookURL, retries)
+	}
+
+	// Serve a built frontend for single-binary deployments. Requests that match
+	// an API route never reach it, and unknown paths fall back to index.html
+	// for client-side routing. The frontend's index.html replaces the
+	// hello-world root route.
+	staticDir := os.Getenv("STATIC_DIR")
+	if staticDir != "" {
+		if _, err := os.Stat(staticDir); err != nil {
+			return nil, fmt.Errorf("invalid STATIC_DIR: %v", err)
+		}
+		e.Use(middleware.StaticWithConfig(middleware.StaticConfig{
+			Skipper: func(c echo.Context) bool {
+				m := c.Request().Method
+				return (m != http.MethodGet && m != http.MethodHead) || c.Path() != ""
+			},
+			Root:  staticDir,
+			HTML5: true,
+		}))
+	}
+
 	// Routes
-	e.GET("/", root)
-	e.POST("/items", addItem)
+	if staticDir == "" {
+		e.GET("/", root)
+	}
+	e.GET("/livez", livez)
+	e.GET("/readyz", readyz)
+	e.POST("/items", addItem, blockInReadOnly, requireContentType)
+	e.GET(EventsPath, getItemEvents)
+	e.Server.RegisterOnShutdown(itemEvents.shutdown)
 	e.GET("/image/:imageFilename", getImg)
+	e.HEAD("/image/:imageFilename", getImg)
 
+	// Middleware is applied per route: group-level middleware would register
+	// catch-all routes, turning unknown /admin paths into auth errors.
+	admin := e.Group("/admin")
+	adminOnly := []echo.MiddlewareFunc{apiKeyAuth(), requireContentType}
+	admin.POST("/loglevel", setLogLevel, adminOnly...)
+	admin.POST("/readonly", setReadOnly, adminOnly...)
+	admin.GET("/images.zip", getImagesZip, adminOnly...)
+	admin.GET("/images/largest", getLargestImages, adminOnly...)
 
-	// Start server
-	e.Logger.Fatal(e.Start(":9000"))
+	// In production the route list requires the API key
+	if os.Getenv("APP_ENV") == "production" {
+		e.GET("/routes", getRoutes, apiKeyAuth())
+	} else {
+		e.GET("/routes", getRoutes)
+	}
+
+	logConfig(e.Logger, log.JSON{
+		"app_env":         os.Getenv("APP_ENV"),
+		"addr":            Addr,
+		"img_dir":         ImgDir,
+		"cors_origins":    origins,
+		"cors_max_age":    corsMaxAge,
+		"log_level":       lvlName(lvl),
+		"read_only":       atomic.LoadInt32(&readOnly) == 1,
+		"request_timeout": timeoutCfg.Timeout.String(),
+		"gzip_level":      gzipCfg.Level,
+		"gzip_min_length": gzipCfg.MinLength,
+		"img_placeholder": imgPlaceholder,
+		"static_dir":      staticDir,
+		"otel_exporter":   os.Getenv("OTEL_EXPORTER"),
+		"debug_pretty":    debugPretty,
+		// The URL may carry a token
+		"webhook_enabled": itemWebhook.url != "",
+		"webhook_retries": itemWebhook.retries,
+	})
+	return e, nil
+}
+
+func main() {
+	e, err := newServer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	atomic.StoreInt32(&ready, 1)
+
+	// Start server, and shut it down on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.Start(Addr)
+	}()
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		e.Logger.Info("Shutting down")
+		atomic.StoreInt32(&ready, 0)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = e.Shutdown(shutdownCtx)
+	}
+
+	// Flush buffered spans and pending webhooks before exiting, including when
+	// the server failed
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	failures, werr := itemWebhook.wait(flushCtx)
+	if werr != nil {
+		e.Logger.Error(werr)
+	}
+	if itemWebhook.url != "" {
+		e.Logger.Infof("Webhook notifications dropped: %d", failures)
+	}
+	if terr := shutdownTracing(flushCtx); terr != nil {
+		e.Logger.Error(terr)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		e.Logger.Fatal(err)
+	}
 }
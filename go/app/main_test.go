@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMain(m *testing.M) {
+	// ImgDir is relative to the go directory, where the server is run from
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPanicReturnsJSONError(t *testing.T) {
+	e, err := newServer()
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	e.GET("/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationJSON) {
+		t.Errorf("Content-Type = %q, want %s", ct, echo.MIMEApplicationJSON)
+	}
+
+	var res ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode body %q: %v", rec.Body.String(), err)
+	}
+	if res.Message != http.StatusText(http.StatusInternalServerError) {
+		t.Errorf("message = %q, want %q", res.Message, http.StatusText(http.StatusInternalServerError))
+	}
+	requestID := rec.Header().Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		t.Errorf("%s header is empty", echo.HeaderXRequestID)
+	}
+	if res.RequestID != requestID {
+		t.Errorf("request_id = %q, want %q (the %s header)", res.RequestID, requestID, echo.HeaderXRequestID)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+const (
+	ServiceName = "mercari-build-training"
+)
+
+// tracingMiddleware starts a span per request when OTEL_EXPORTER=otlp.
+// Spans are exported over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT
+// (default localhost:4318). The returned shutdown func flushes buffered spans
+// and must be called on exit. When tracing is disabled the middleware is nil
+// and shutdown does nothing.
+func tracingMiddleware() (echo.MiddlewareFunc, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	switch exporter := os.Getenv("OTEL_EXPORTER"); exporter {
+	case "", "none":
+		return nil, noop, nil
+	case "otlp":
+	default:
+		return nil, noop, fmt.Errorf("unsupported OTEL_EXPORTER: %q", exporter)
+	}
+
+	exp, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, noop, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return otelecho.Middleware(ServiceName, otelecho.WithTracerProvider(tp)), tp.Shutdown, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultWebhookRetries = 3
+	webhookTimeout        = 5 * time.Second
+)
+
+// webhook POSTs JSON notifications to WEBHOOK_URL. A zero value (no URL)
+// is disabled.
+type webhook struct {
+	url     string
+	retries int
+	client  *http.Client
+	// failures counts notifications that were dropped after all retries.
+	// It is logged on shutdown.
+	failures int64
+	// pending tracks the notifications still being sent.
+	pending sync.WaitGroup
+}
+
+var itemWebhook = &webhook{}
+
+func newWebhook(url string, retries int) *webhook {
+	return &webhook{
+		url:     url,
+		retries: retries,
+		client:  &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// notify sends payload in the background, retrying with exponential backoff.
+// Failures are logged and counted but never returned to the caller.
+func (w *webhook) notify(logger echo.Logger, payload interface{}) {
+	if w.url == "" {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Errorf("Failed to encode webhook payload: %v", err)
+		return
+	}
+
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		backoff := 500 * time.Millisecond
+		for attempt := 0; ; attempt++ {
+			err := w.post(body)
+			if err == nil {
+				return
+			}
+			if attempt >= w.retries {
+				n := atomic.AddInt64(&w.failures, 1)
+				logger.Errorf("Webhook failed after %d attempts (%d failures in total): %v", attempt+1, n, err)
+				return
+			}
+			logger.Warnf("Webhook attempt %d failed, retrying in %s: %v", attempt+1, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
+
+// wait blocks until the pending notifications are sent or dropped, or ctx is
+// done. It returns the number of notifications dropped so far.
+func (w *webhook) wait(ctx context.Context) (int64, error) {
+	done := make(chan struct{})
+	go func() {
+		w.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return atomic.LoadInt64(&w.failures), fmt.Errorf("webhook notifications still pending: %w", ctx.Err())
+	}
+	return atomic.LoadInt64(&w.failures), nil
+}
+
+func (w *webhook) post(body []byte) error {
+	res, err := w.client.Post(w.url, echo.MIMEApplicationJSON, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}